@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Directive is one instruction line in a Dockerfile, e.g.
+// {Op: "RUN", Args: []string{"apk add --no-cache tini"}}.
+type Directive struct {
+	Op   string
+	Args []string
+	// JSONForm renders Args as a JSON array (Dockerfile "exec form"), the
+	// way CMD and ENTRYPOINT are conventionally written.
+	JSONForm bool
+}
+
+// String renders d as a single Dockerfile line, indented to match the rest
+// of the generated file.
+func (d Directive) String() string {
+	if d.JSONForm {
+		quoted := make([]string, len(d.Args))
+		for i, a := range d.Args {
+			quoted[i] = strconv.Quote(a)
+		}
+		return "  " + d.Op + " [" + strings.Join(quoted, ", ") + "]"
+	}
+	return "  " + d.Op + " " + strings.Join(d.Args, " ")
+}
+
+// formatDirectives renders directives as a complete Dockerfile.
+func formatDirectives(directives []Directive) string {
+	var b strings.Builder
+	for _, d := range directives {
+		b.WriteString(d.String())
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// runImageBuild builds the image described by dockerfilePath/contextDir
+// using the given backend ("daemon" or "buildah"). The ggcr backend is
+// handled separately in doBuild since it doesn't go through a Dockerfile at
+// all.
+func runImageBuild(backend, dockerfilePath, contextDir, tag string, buildArgs map[string]string) error {
+	var name string
+	var args []string
+	switch backend {
+	case "daemon", "":
+		name = "docker"
+		args = []string{"build", "-f", dockerfilePath}
+	case "buildah":
+		name = "buildah"
+		args = []string{"bud", "-f", dockerfilePath}
+	default:
+		return fmt.Errorf("invalid --backend %q: expected daemon, ggcr, or buildah", backend)
+	}
+	for k, v := range buildArgs {
+		args = append(args, "--build-arg", k+"="+v)
+	}
+	if tag != "" {
+		args = append(args, "-t", tag)
+	}
+	args = append(args, ".")
+
+	cmd := exec.Command(name, args...)
+	cmd.Dir = contextDir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// pushImage pushes tag to its registry using the given backend. This is
+// required before buildManifest, since `docker manifest`/`buildx imagetools`
+// reference images by how they look in the registry, not the local image
+// store that runImageBuild populated.
+func pushImage(backend, tag string) error {
+	var name string
+	switch backend {
+	case "daemon", "":
+		name = "docker"
+	case "buildah":
+		name = "buildah"
+	default:
+		return fmt.Errorf("invalid --backend %q: expected daemon, ggcr, or buildah", backend)
+	}
+	cmd := exec.Command(name, "push", tag)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
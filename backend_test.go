@@ -0,0 +1,31 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPushImage(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "docker.log")
+	writeFakeDocker(t, logPath)
+
+	if err := pushImage("daemon", "my/image:latest-amd64"); err != nil {
+		t.Fatalf("pushImage: %s", err)
+	}
+
+	log, err := ioutil.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(log)) != "push my/image:latest-amd64" {
+		t.Errorf("docker invocation = %q, want %q", log, "push my/image:latest-amd64")
+	}
+}
+
+func TestPushImageInvalidBackend(t *testing.T) {
+	if err := pushImage("bogus", "my/image:latest"); err == nil {
+		t.Fatal("expected error for invalid backend")
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// basePlatform knows how to translate the generic, package-manager-agnostic
+// bits of a Dockerfile (installing packages, adding extra apk/apt/dnf
+// repositories, creating the app user) into the syntax of a particular base
+// image family.
+type basePlatform interface {
+	// Name identifies the family, e.g. "alpine", "debian", "fedora".
+	Name() string
+
+	// AllowInstall reports whether this family can install packages at all.
+	// distroless and scratch images have no package manager.
+	AllowInstall() bool
+
+	// TranslatePackage maps a generic package name (as written in a
+	// //docker:install directive) to the name used by this family's package
+	// manager, e.g. "bind-tools" -> "dnsutils" on Debian.
+	TranslatePackage(pkg string) string
+
+	// InstallCmd returns the RUN command line that installs packages
+	// (already translated via TranslatePackage), or "" if packages is empty.
+	InstallCmd(packages []string) string
+
+	// RepositoryCmds returns the RUN command lines that add the given
+	// //docker:repository entries as extra package sources.
+	RepositoryCmds(repos []string) []string
+
+	// EdgeCmd returns the RUN command line that enables the "@edge"
+	// repository convention, and whether this family supports it at all.
+	EdgeCmd() (string, bool)
+
+	// AddUserCmd returns the RUN command line that creates user (and chowns
+	// userDirs to it), or "" if this family can't create users (e.g.
+	// distroless has no shell to run useradd in).
+	AddUserCmd(user string, userDirs []string) string
+}
+
+// packageAliases maps generic package names to their name in each base
+// family's package manager, for the common cases where the name differs.
+// Families and packages not listed here use the generic name unchanged.
+var packageAliases = map[string]map[string]string{
+	"debian": {
+		"bind-tools": "dnsutils",
+		"mailcap":    "mime-support",
+	},
+	"fedora": {
+		"bind-tools": "bind-utils",
+	},
+}
+
+func translatePackage(family, pkg string) string {
+	if alias, ok := packageAliases[family][pkg]; ok {
+		return alias
+	}
+	return pkg
+}
+
+// detectBasePlatform picks a basePlatform for baseImage, honoring an
+// explicit --base-family override.
+func detectBasePlatform(baseImage, override string) (basePlatform, error) {
+	family := override
+	if family == "" {
+		switch {
+		case strings.HasPrefix(baseImage, "alpine"):
+			family = "alpine"
+		case strings.HasPrefix(baseImage, "debian"), strings.HasPrefix(baseImage, "ubuntu"):
+			family = "debian"
+		case strings.HasPrefix(baseImage, "fedora"), strings.HasPrefix(baseImage, "centos"), strings.HasPrefix(baseImage, "rhel"):
+			family = "fedora"
+		case baseImage == "scratch", strings.Contains(baseImage, "distroless"):
+			family = "distroless"
+		default:
+			family = "alpine"
+		}
+	}
+
+	switch family {
+	case "alpine":
+		return alpinePlatform{}, nil
+	case "debian", "ubuntu":
+		return debianPlatform{}, nil
+	case "fedora", "centos", "rhel":
+		return fedoraPlatform{}, nil
+	case "distroless", "scratch":
+		return distrolessPlatform{}, nil
+	default:
+		return nil, fmt.Errorf("invalid --base-family %q: expected alpine, debian, fedora, or distroless", family)
+	}
+}
+
+type alpinePlatform struct{}
+
+func (alpinePlatform) Name() string                     { return "alpine" }
+func (alpinePlatform) AllowInstall() bool               { return true }
+func (alpinePlatform) TranslatePackage(p string) string { return translatePackage("alpine", p) }
+
+func (alpinePlatform) InstallCmd(packages []string) string {
+	if len(packages) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("apk add --no-cache %s", strings.Join(sortedStringSet(packages), " "))
+}
+
+func (alpinePlatform) RepositoryCmds(repos []string) []string {
+	var cmds []string
+	for _, repo := range repos {
+		cmds = append(cmds, fmt.Sprintf(`echo -e "http://dl-cdn.alpinelinux.org/alpine/%s/main\n" >> /etc/apk/repositories && \
+    echo -e "http://dl-cdn.alpinelinux.org/alpine/%s/community\n" >> /etc/apk/repositories`, repo, repo))
+	}
+	return cmds
+}
+
+func (alpinePlatform) EdgeCmd() (string, bool) {
+	return `echo -e "@edge http://dl-cdn.alpinelinux.org/alpine/edge/main\n" >> /etc/apk/repositories && \
+    echo -e "@edge http://dl-cdn.alpinelinux.org/alpine/edge/community\n" >> /etc/apk/repositories`, true
+}
+
+func (alpinePlatform) AddUserCmd(user string, userDirs []string) string {
+	cmds := []string{fmt.Sprintf("addgroup -S %s && adduser -S -G %s -h /home/%s %s", user, user, user, user)}
+	for _, dir := range userDirs {
+		cmds = append(cmds, fmt.Sprintf("mkdir -p %s && chown -R %s:%s %s", dir, user, user, dir))
+	}
+	return strings.Join(cmds, " && ")
+}
+
+type debianPlatform struct{}
+
+func (debianPlatform) Name() string                     { return "debian" }
+func (debianPlatform) AllowInstall() bool               { return true }
+func (debianPlatform) TranslatePackage(p string) string { return translatePackage("debian", p) }
+
+func (debianPlatform) InstallCmd(packages []string) string {
+	if len(packages) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("apt-get update && apt-get install -y --no-install-recommends %s && rm -rf /var/lib/apt/lists/*", strings.Join(sortedStringSet(packages), " "))
+}
+
+func (debianPlatform) RepositoryCmds(repos []string) []string {
+	var cmds []string
+	for _, repo := range repos {
+		cmds = append(cmds, fmt.Sprintf("echo %q >> /etc/apt/sources.list", repo))
+	}
+	return cmds
+}
+
+func (debianPlatform) EdgeCmd() (string, bool) { return "", false }
+
+func (debianPlatform) AddUserCmd(user string, userDirs []string) string {
+	cmds := []string{fmt.Sprintf("groupadd -r %s && useradd -r -g %s -d /home/%s -m %s", user, user, user, user)}
+	for _, dir := range userDirs {
+		cmds = append(cmds, fmt.Sprintf("mkdir -p %s && chown -R %s:%s %s", dir, user, user, dir))
+	}
+	return strings.Join(cmds, " && ")
+}
+
+type fedoraPlatform struct{}
+
+func (fedoraPlatform) Name() string                     { return "fedora" }
+func (fedoraPlatform) AllowInstall() bool               { return true }
+func (fedoraPlatform) TranslatePackage(p string) string { return translatePackage("fedora", p) }
+
+func (fedoraPlatform) InstallCmd(packages []string) string {
+	if len(packages) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("dnf install -y %s && dnf clean all", strings.Join(sortedStringSet(packages), " "))
+}
+
+func (fedoraPlatform) RepositoryCmds(repos []string) []string {
+	var cmds []string
+	for _, repo := range repos {
+		cmds = append(cmds, fmt.Sprintf("dnf config-manager --add-repo %s", repo))
+	}
+	return cmds
+}
+
+func (fedoraPlatform) EdgeCmd() (string, bool) { return "", false }
+
+func (fedoraPlatform) AddUserCmd(user string, userDirs []string) string {
+	cmds := []string{fmt.Sprintf("groupadd -r %s && useradd -r -g %s -d /home/%s -m %s", user, user, user, user)}
+	for _, dir := range userDirs {
+		cmds = append(cmds, fmt.Sprintf("mkdir -p %s && chown -R %s:%s %s", dir, user, user, dir))
+	}
+	return strings.Join(cmds, " && ")
+}
+
+// distrolessPlatform covers distroless and scratch base images, neither of
+// which has a package manager or a shell to create users with.
+type distrolessPlatform struct{}
+
+func (distrolessPlatform) Name() string                                     { return "distroless" }
+func (distrolessPlatform) AllowInstall() bool                               { return false }
+func (distrolessPlatform) TranslatePackage(p string) string                 { return p }
+func (distrolessPlatform) InstallCmd(packages []string) string              { return "" }
+func (distrolessPlatform) RepositoryCmds(repos []string) []string           { return nil }
+func (distrolessPlatform) EdgeCmd() (string, bool)                          { return "", false }
+func (distrolessPlatform) AddUserCmd(user string, userDirs []string) string { return "" }
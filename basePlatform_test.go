@@ -0,0 +1,90 @@
+package main
+
+import "testing"
+
+func TestDetectBasePlatform(t *testing.T) {
+	tests := []struct {
+		baseImage string
+		override  string
+		wantName  string
+		wantErr   bool
+	}{
+		{"alpine:3.18", "", "alpine", false},
+		{"debian:bookworm-slim", "", "debian", false},
+		{"ubuntu:22.04", "", "debian", false},
+		{"fedora:39", "", "fedora", false},
+		{"centos:7", "", "fedora", false},
+		{"gcr.io/distroless/static", "", "distroless", false},
+		{"scratch", "", "distroless", false},
+		{"golang:1.21", "", "alpine", false},
+		{"golang:1.21", "debian", "debian", false},
+		{"alpine:3.18", "bogus", "", true},
+	}
+	for _, tc := range tests {
+		fam, err := detectBasePlatform(tc.baseImage, tc.override)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("detectBasePlatform(%q, %q): expected error", tc.baseImage, tc.override)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("detectBasePlatform(%q, %q): unexpected error: %s", tc.baseImage, tc.override, err)
+			continue
+		}
+		if fam.Name() != tc.wantName {
+			t.Errorf("detectBasePlatform(%q, %q).Name() = %q, want %q", tc.baseImage, tc.override, fam.Name(), tc.wantName)
+		}
+	}
+}
+
+func TestTranslatePackage(t *testing.T) {
+	tests := []struct {
+		family, pkg, want string
+	}{
+		{"debian", "bind-tools", "dnsutils"},
+		{"debian", "mailcap", "mime-support"},
+		{"fedora", "bind-tools", "bind-utils"},
+		{"alpine", "bind-tools", "bind-tools"},
+		{"debian", "curl", "curl"},
+	}
+	for _, tc := range tests {
+		if got := translatePackage(tc.family, tc.pkg); got != tc.want {
+			t.Errorf("translatePackage(%q, %q) = %q, want %q", tc.family, tc.pkg, got, tc.want)
+		}
+	}
+}
+
+func TestAlpinePlatformInstallCmd(t *testing.T) {
+	fam := alpinePlatform{}
+	if got := fam.InstallCmd(nil); got != "" {
+		t.Errorf("InstallCmd(nil) = %q, want empty", got)
+	}
+	got := fam.InstallCmd([]string{"tini", "ca-certificates"})
+	want := "apk add --no-cache ca-certificates tini"
+	if got != want {
+		t.Errorf("InstallCmd(...) = %q, want %q", got, want)
+	}
+}
+
+func TestDistrolessPlatformHasNoInstallOrUser(t *testing.T) {
+	fam := distrolessPlatform{}
+	if fam.AllowInstall() {
+		t.Error("distrolessPlatform.AllowInstall() = true, want false")
+	}
+	if got := fam.AddUserCmd("app", nil); got != "" {
+		t.Errorf("AddUserCmd(...) = %q, want empty", got)
+	}
+	if _, ok := fam.EdgeCmd(); ok {
+		t.Error("distrolessPlatform.EdgeCmd() ok = true, want false")
+	}
+}
+
+func TestAlpinePlatformEdgeCmd(t *testing.T) {
+	if _, ok := (alpinePlatform{}).EdgeCmd(); !ok {
+		t.Error("alpinePlatform.EdgeCmd() ok = false, want true")
+	}
+	if _, ok := (debianPlatform{}).EdgeCmd(); ok {
+		t.Error("debianPlatform.EdgeCmd() ok = true, want false")
+	}
+}
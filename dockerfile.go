@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/urfave/cli"
+)
+
+// generateDockerfile renders the Dockerfile for a single target platform.
+// packages, install, env, expose, repos, run, user, userDirs, and cmd come
+// from the //docker: directives collected in doBuild. When multiStage is
+// set, a `golang` builder stage compiles the binaries inside the image
+// instead of expecting them to already be present in the build context.
+//
+// The runtime stage is built as an AST of Directives rather than
+// concatenated strings, so it can be spliced into a user-supplied
+// --dockerfile-template at its "# godockerize:inject" marker.
+func generateDockerfile(c *cli.Context, platform Platform, spec *imageSpec, multiStage bool, goVersion string) (bytes.Buffer, error) {
+	// spec is shared across the --platform loop in doBuild, so filtering
+	// must produce a new slice rather than writing back into spec.Install -
+	// otherwise the first platform's filtering would permanently drop the
+	// other platforms' arch-conditional packages.
+	install := filterArchPackages(spec.Install, platform)
+
+	fam, err := detectBasePlatform(c.String("base"), c.String("base-family"))
+	if err != nil {
+		return bytes.Buffer{}, err
+	}
+
+	directives, err := buildDirectives(c, fam, platform, spec, install, multiStage)
+	if err != nil {
+		return bytes.Buffer{}, err
+	}
+
+	if tmplPath := c.String("dockerfile-template"); tmplPath != "" {
+		data, err := ioutil.ReadFile(tmplPath)
+		if err != nil {
+			return bytes.Buffer{}, err
+		}
+		before, after, err := parseDockerfileTemplate(string(data))
+		if err != nil {
+			return bytes.Buffer{}, err
+		}
+		directives, err = mergeDirectives(before, directives, after)
+		if err != nil {
+			return bytes.Buffer{}, err
+		}
+	}
+
+	var dockerfile bytes.Buffer
+	if multiStage {
+		writeBuilderStage(&dockerfile, goVersion, spec.Packages, c.StringSlice("go-build-flags"), platform)
+		dockerfile.WriteString("\n")
+	}
+	dockerfile.WriteString(formatDirectives(directives))
+	return dockerfile, nil
+}
+
+// buildDirectives builds the AST for the runtime stage: the pre-FROM ARGs,
+// FROM, post-FROM ARGs and LABELs, the install/repository/user RUN commands
+// (dispatched through fam), the //docker: env/expose/volume/workdir/run/cmd
+// directives, the HEALTHCHECK, and the ENTRYPOINT plus ADD/COPY lines that
+// install the compiled binaries. install is spec.Install already filtered
+// for platform by the caller.
+func buildDirectives(c *cli.Context, fam basePlatform, platform Platform, spec *imageSpec, install []string, multiStage bool) ([]Directive, error) {
+	var directives []Directive
+	for _, arg := range spec.PreArgs {
+		directives = append(directives, Directive{Op: "ARG", Args: []string{arg}})
+	}
+	directives = append(directives, Directive{Op: "FROM", Args: []string{c.String("base")}})
+	directives = append(directives, Directive{Op: "ARG", Args: []string{"TARGETARCH=" + platform.TargetArch()}})
+	for _, arg := range spec.PostArgs {
+		directives = append(directives, Directive{Op: "ARG", Args: []string{arg}})
+	}
+	if len(spec.Labels) != 0 {
+		directives = append(directives, Directive{Op: "LABEL", Args: sortedStringSet(spec.Labels)})
+	}
+
+	var edgeRequested bool
+	translated := make([]string, 0, len(install))
+	for _, pkg := range install {
+		if strings.HasSuffix(pkg, "@edge") {
+			edgeRequested = true
+		}
+		translated = append(translated, fam.TranslatePackage(pkg))
+	}
+	install = translated
+
+	if edgeRequested {
+		edgeCmd, ok := fam.EdgeCmd()
+		if !ok {
+			return nil, fmt.Errorf("@edge packages are only supported on alpine, not %s", fam.Name())
+		}
+		directives = append(directives, Directive{Op: "RUN", Args: []string{edgeCmd}})
+	}
+	for _, repoCmd := range fam.RepositoryCmds(spec.Repos) {
+		directives = append(directives, Directive{Op: "RUN", Args: []string{repoCmd}})
+	}
+	if fam.Name() == "alpine" {
+		// IMPORTANT: Alpine by default does not come with some packages that
+		// are needed for working DNS to other containers on a user-defined
+		// Docker network. Without installing this package, nslookup and Go etc
+		// will fail to contact other Docker containers.
+		// See https://github.com/sourcegraph/deploy-sourcegraph-docker/issues/1
+		install = append(install, "bind-tools")
+	}
+	if len(install) != 0 {
+		if !fam.AllowInstall() {
+			return nil, fmt.Errorf("//docker:install is not supported on %s base images (%s); requested packages: %s", fam.Name(), c.String("base"), strings.Join(sortedStringSet(install), ", "))
+		}
+		directives = append(directives, Directive{Op: "RUN", Args: []string{fam.InstallCmd(install)}})
+	}
+	if spec.User != "" {
+		addUserCmd := fam.AddUserCmd(spec.User, spec.UserDirs)
+		if addUserCmd == "" {
+			return nil, fmt.Errorf("//docker:user is not supported on %s base images; there's no shell to create %q with", fam.Name(), spec.User)
+		}
+		directives = append(directives, Directive{Op: "RUN", Args: []string{addUserCmd}})
+	}
+	for _, runCmd := range spec.Run {
+		directives = append(directives, Directive{Op: "RUN", Args: []string{runCmd}})
+	}
+	if len(spec.Env) != 0 {
+		directives = append(directives, Directive{Op: "ENV", Args: sortedStringSet(spec.Env)})
+	}
+	if len(spec.Expose) != 0 {
+		directives = append(directives, Directive{Op: "EXPOSE", Args: sortedStringSet(spec.Expose)})
+	}
+	if len(spec.Volumes) != 0 {
+		directives = append(directives, Directive{Op: "VOLUME", Args: sortedStringSet(spec.Volumes), JSONForm: true})
+	}
+	if spec.Workdir != "" {
+		directives = append(directives, Directive{Op: "WORKDIR", Args: []string{spec.Workdir}})
+	}
+	if spec.User != "" {
+		directives = append(directives, Directive{Op: "USER", Args: []string{spec.User}})
+	}
+	if spec.Healthcheck != "" {
+		directives = append(directives, Directive{Op: "HEALTHCHECK", Args: []string{healthcheckCmd(spec.Healthcheck)}})
+	}
+	if spec.Cmd != "" {
+		directives = append(directives, Directive{Op: "CMD", Args: []string{spec.Cmd}})
+	}
+	if spec.Entrypoint != "" {
+		var entrypointArgs []string
+		if err := json.Unmarshal([]byte(spec.Entrypoint), &entrypointArgs); err != nil {
+			return nil, fmt.Errorf("//docker:entrypoint must be a JSON array, e.g. [\"/usr/local/bin/foo\"]: %s", err)
+		}
+		directives = append(directives, Directive{Op: "ENTRYPOINT", Args: entrypointArgs, JSONForm: true})
+	} else {
+		directives = append(directives, Directive{
+			Op:       "ENTRYPOINT",
+			Args:     []string{"/sbin/tini", "--", "/usr/local/bin/" + path.Base(spec.Packages[0])},
+			JSONForm: true,
+		})
+	}
+	for _, importPath := range spec.Packages {
+		if multiStage {
+			directives = append(directives, Directive{Op: "COPY", Args: []string{"--from=builder", "/out/" + path.Base(importPath), "/usr/local/bin/"}})
+		} else {
+			directives = append(directives, Directive{Op: "ADD", Args: []string{path.Base(importPath), "/usr/local/bin/"}})
+		}
+	}
+
+	return directives, nil
+}
+
+// healthcheckCmd splits a //docker:healthcheck directive's body into its
+// leading "--flag=value" options and the trailing probe command, and
+// prefixes the command with "CMD" the way Dockerfile's HEALTHCHECK expects.
+func healthcheckCmd(body string) string {
+	fields := strings.Fields(body)
+	var flags []string
+	i := 0
+	for ; i < len(fields); i++ {
+		if !strings.HasPrefix(fields[i], "--") {
+			break
+		}
+		flags = append(flags, fields[i])
+	}
+	cmd := strings.Join(fields[i:], " ")
+	if len(flags) == 0 {
+		return "CMD " + cmd
+	}
+	return strings.Join(flags, " ") + " CMD " + cmd
+}
+
+// filterArchPackages resolves arch-conditional install directives of the
+// form "arch:package" (e.g. "arm64:some-pkg") to "package" when they match
+// platform, and drops them otherwise. Packages without an "arch:" prefix are
+// always included.
+func filterArchPackages(install []string, platform Platform) []string {
+	var out []string
+	for _, pkg := range install {
+		if arch, name, ok := splitArchPackage(pkg); ok {
+			if arch == platform.Arch {
+				out = append(out, name)
+			}
+			continue
+		}
+		out = append(out, pkg)
+	}
+	return out
+}
+
+func splitArchPackage(pkg string) (arch, name string, ok bool) {
+	switch {
+	case strings.HasPrefix(pkg, "amd64:"), strings.HasPrefix(pkg, "arm64:"), strings.HasPrefix(pkg, "arm:"), strings.HasPrefix(pkg, "386:"):
+		parts := strings.SplitN(pkg, ":", 2)
+		return parts[0], parts[1], true
+	default:
+		return "", "", false
+	}
+}
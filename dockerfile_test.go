@@ -0,0 +1,154 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+
+	"github.com/urfave/cli"
+)
+
+// newTestContext builds a *cli.Context with the given string flags set, for
+// exercising buildDirectives/generateDockerfile without going through
+// cli.App.Run.
+func newTestContext(t *testing.T, flags map[string]string) *cli.Context {
+	t.Helper()
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	for name, value := range flags {
+		set.String(name, value, "")
+	}
+	return cli.NewContext(nil, set, nil)
+}
+
+func TestHealthcheckCmd(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{"curl -f http://localhost/ || exit 1", "CMD curl -f http://localhost/ || exit 1"},
+		{"--interval=5s --timeout=3s curl -f http://localhost/", "--interval=5s --timeout=3s CMD curl -f http://localhost/"},
+	}
+	for _, tc := range tests {
+		if got := healthcheckCmd(tc.body); got != tc.want {
+			t.Errorf("healthcheckCmd(%q) = %q, want %q", tc.body, got, tc.want)
+		}
+	}
+}
+
+func TestFilterArchPackages(t *testing.T) {
+	amd64 := Platform{OS: "linux", Arch: "amd64"}
+	arm64 := Platform{OS: "linux", Arch: "arm64"}
+	install := []string{"tini", "amd64:gcompat", "arm64:foo"}
+
+	got := filterArchPackages(install, amd64)
+	want := []string{"tini", "gcompat"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("filterArchPackages(amd64) = %v, want %v", got, want)
+	}
+
+	got = filterArchPackages(install, arm64)
+	want = []string{"tini", "foo"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("filterArchPackages(arm64) = %v, want %v", got, want)
+	}
+}
+
+func TestFilterArchPackagesDoesNotMutateSpec(t *testing.T) {
+	// Regression test: generateDockerfile must not write the filtered result
+	// back into spec.Install, since spec is shared across the --platform
+	// loop in doBuild. A second platform filtered after a first must still
+	// see packages meant only for it.
+	spec := &imageSpec{
+		Packages: []string{"example.com/foo"},
+		Install:  []string{"tini", "amd64:gcompat", "arm64:foo"},
+	}
+	original := append([]string(nil), spec.Install...)
+
+	c := newTestContext(t, map[string]string{"base": "alpine:3.18", "base-family": ""})
+	if _, err := generateDockerfile(c, Platform{OS: "linux", Arch: "amd64"}, spec, false, "1.21"); err != nil {
+		t.Fatalf("generateDockerfile(amd64): %s", err)
+	}
+	if strings.Join(spec.Install, ",") != strings.Join(original, ",") {
+		t.Fatalf("spec.Install was mutated: got %v, want %v", spec.Install, original)
+	}
+
+	dockerfile, err := generateDockerfile(c, Platform{OS: "linux", Arch: "arm64"}, spec, false, "1.21")
+	if err != nil {
+		t.Fatalf("generateDockerfile(arm64): %s", err)
+	}
+	if !strings.Contains(dockerfile.String(), "foo") {
+		t.Errorf("arm64 Dockerfile missing arm64-only package %q; spec.Install was likely mutated by the amd64 pass: %s", "foo", dockerfile.String())
+	}
+}
+
+func TestBuildDirectivesOrderingAndDirectives(t *testing.T) {
+	spec := &imageSpec{
+		Packages:    []string{"example.com/foo"},
+		PreArgs:     []string{"BUILD_ENV"},
+		PostArgs:    []string{"BUILD_ENV=prod"},
+		Labels:      []string{"maintainer=team"},
+		Volumes:     []string{"/data"},
+		Workdir:     "/srv",
+		Healthcheck: "curl -f http://localhost/ || exit 1",
+		User:        "app",
+	}
+	c := newTestContext(t, map[string]string{"base": "alpine:3.18"})
+	directives, err := buildDirectives(c, alpinePlatform{}, Platform{OS: "linux", Arch: "amd64"}, spec, spec.Install, false)
+	if err != nil {
+		t.Fatalf("buildDirectives: %s", err)
+	}
+
+	var ops []string
+	for _, d := range directives {
+		ops = append(ops, d.Op)
+	}
+
+	indexOf := func(op string) int {
+		for i, o := range ops {
+			if o == op {
+				return i
+			}
+		}
+		t.Fatalf("directive %s not found in %v", op, ops)
+		return -1
+	}
+
+	// The pre-FROM ARG must precede FROM, and the post-FROM ARG plus LABEL
+	// must follow it, so a --dockerfile-template splice never reorders them
+	// relative to FROM.
+	argIdx := indexOf("ARG")
+	fromIdx := indexOf("FROM")
+	labelIdx := indexOf("LABEL")
+	volumeIdx := indexOf("VOLUME")
+	workdirIdx := indexOf("WORKDIR")
+	healthcheckIdx := indexOf("HEALTHCHECK")
+
+	if argIdx > fromIdx {
+		t.Errorf("ARG at %d must come before FROM at %d", argIdx, fromIdx)
+	}
+	if labelIdx < fromIdx {
+		t.Errorf("LABEL at %d must come after FROM at %d", labelIdx, fromIdx)
+	}
+	if workdirIdx < volumeIdx {
+		t.Errorf("WORKDIR at %d should come after VOLUME at %d", workdirIdx, volumeIdx)
+	}
+	if healthcheckIdx < workdirIdx {
+		t.Errorf("HEALTHCHECK at %d should come after WORKDIR at %d", healthcheckIdx, workdirIdx)
+	}
+}
+
+func TestBuildDirectivesUserAndHealthcheckMissing(t *testing.T) {
+	spec := &imageSpec{Packages: []string{"example.com/foo"}}
+	c := newTestContext(t, map[string]string{"base": "alpine:3.18"})
+	directives, err := buildDirectives(c, alpinePlatform{}, Platform{OS: "linux", Arch: "amd64"}, spec, spec.Install, false)
+	if err != nil {
+		t.Fatalf("buildDirectives: %s", err)
+	}
+	for _, op := range []string{"USER", "HEALTHCHECK", "LABEL", "VOLUME", "WORKDIR"} {
+		for _, d := range directives {
+			if d.Op == op {
+				t.Errorf("unexpected %s directive when spec has none set", op)
+			}
+		}
+	}
+}
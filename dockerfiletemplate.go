@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// injectMarker is the line a --dockerfile-template file must contain exactly
+// once; godockerize's own generated directives are spliced in at that point.
+const injectMarker = "# godockerize:inject"
+
+var varRefRe = regexp.MustCompile(`\$\{?([A-Za-z_][A-Za-z0-9_]*)\}?`)
+
+// parseDockerfileTemplate tokenizes a user-supplied Dockerfile template,
+// splitting it into the directives that come before and after the
+// "# godockerize:inject" marker. It understands line continuations, quoted
+// strings, the JSON-array form of CMD/ENTRYPOINT, and $VAR/${VAR} expansion
+// of ARG/ENV values declared earlier in the same template.
+func parseDockerfileTemplate(data string) (before, after []Directive, err error) {
+	lines, err := joinContinuations(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	vars := map[string]string{}
+	sawMarker := false
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == injectMarker {
+			if sawMarker {
+				return nil, nil, fmt.Errorf("dockerfile template contains %q more than once", injectMarker)
+			}
+			sawMarker = true
+			continue
+		}
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		d, err := parseDirectiveLine(trimmed, vars)
+		if err != nil {
+			return nil, nil, err
+		}
+		if (d.Op == "ARG" || d.Op == "ENV") && len(d.Args) == 1 {
+			if name, value, ok := splitKeyValue(d.Args[0]); ok {
+				vars[name] = value
+			}
+		}
+
+		if sawMarker {
+			after = append(after, d)
+		} else {
+			before = append(before, d)
+		}
+	}
+
+	if !sawMarker {
+		return nil, nil, fmt.Errorf("dockerfile template has no %q marker line", injectMarker)
+	}
+	return before, after, nil
+}
+
+// joinContinuations splits data into logical lines, joining any line ending
+// in an unescaped "\" with the line that follows it.
+func joinContinuations(data string) ([]string, error) {
+	raw := strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n")
+	var lines []string
+	var cur string
+	for _, l := range raw {
+		if cur != "" {
+			l = cur + " " + strings.TrimSpace(l)
+			cur = ""
+		}
+		if strings.HasSuffix(strings.TrimRight(l, " \t"), "\\") {
+			cur = strings.TrimSuffix(strings.TrimRight(l, " \t"), "\\")
+			continue
+		}
+		lines = append(lines, l)
+	}
+	if cur != "" {
+		return nil, fmt.Errorf("dockerfile template ends with a dangling line continuation")
+	}
+	return lines, nil
+}
+
+// parseDirectiveLine parses a single logical (continuation-joined) line
+// into a Directive, expanding $VAR/${VAR} references against vars.
+func parseDirectiveLine(line string, vars map[string]string) (Directive, error) {
+	fields := strings.SplitN(line, " ", 2)
+	op := strings.ToUpper(fields[0])
+	if len(fields) == 1 {
+		return Directive{Op: op}, nil
+	}
+	rest := expandVars(strings.TrimSpace(fields[1]), vars)
+
+	if (op == "CMD" || op == "ENTRYPOINT") && strings.HasPrefix(rest, "[") {
+		var args []string
+		if err := json.Unmarshal([]byte(rest), &args); err != nil {
+			return Directive{}, fmt.Errorf("parsing JSON-array %s: %s", op, err)
+		}
+		return Directive{Op: op, Args: args, JSONForm: true}, nil
+	}
+	return Directive{Op: op, Args: []string{rest}}, nil
+}
+
+func expandVars(s string, vars map[string]string) string {
+	return varRefRe.ReplaceAllStringFunc(s, func(ref string) string {
+		name := varRefRe.FindStringSubmatch(ref)[1]
+		if v, ok := vars[name]; ok {
+			return v
+		}
+		return ref
+	})
+}
+
+func splitKeyValue(s string) (key, value string, ok bool) {
+	i := strings.Index(s, "=")
+	if i < 0 {
+		return "", "", false
+	}
+	return s[:i], s[i+1:], true
+}
+
+// conflictingOps are directives godockerize generates that a user's template
+// would also plausibly set; having both is ambiguous, so mergeDirectives
+// rejects it rather than silently picking one. FROM is included because
+// generated always carries exactly one FROM (the --base image): splicing it
+// in after a template's own FROM wouldn't override that FROM, it would
+// silently start a second, anonymous build stage.
+var conflictingOps = map[string]bool{
+	"ENTRYPOINT": true,
+	"CMD":        true,
+	"FROM":       true,
+}
+
+// mergeDirectives splices generated in between a template's before/after
+// directives (i.e. at the "# godockerize:inject" marker), erroring if the
+// template sets a directive godockerize's //docker: directives would also
+// set.
+func mergeDirectives(before, generated, after []Directive) ([]Directive, error) {
+	templateOps := map[string]bool{}
+	for _, d := range before {
+		templateOps[d.Op] = true
+	}
+	for _, d := range after {
+		templateOps[d.Op] = true
+	}
+	for _, d := range generated {
+		if conflictingOps[d.Op] && templateOps[d.Op] {
+			return nil, fmt.Errorf("dockerfile template sets %s, which conflicts with the %s godockerize generates from //docker: directives; remove one", d.Op, d.Op)
+		}
+	}
+
+	merged := make([]Directive, 0, len(before)+len(generated)+len(after))
+	merged = append(merged, before...)
+	merged = append(merged, generated...)
+	merged = append(merged, after...)
+	return merged, nil
+}
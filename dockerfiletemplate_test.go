@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseDockerfileTemplate(t *testing.T) {
+	data := `# a comment before the marker
+ARG BASE_TAG=1.0
+# godockerize:inject
+LABEL version="${BASE_TAG}"
+ENTRYPOINT ["/bin/sh", "-c", \
+  "echo hi"]
+`
+	before, after, err := parseDockerfileTemplate(data)
+	if err != nil {
+		t.Fatalf("parseDockerfileTemplate: %s", err)
+	}
+	if len(before) != 1 || before[0].Op != "ARG" || before[0].Args[0] != "BASE_TAG=1.0" {
+		t.Fatalf("before = %+v, want a single ARG BASE_TAG=1.0", before)
+	}
+	if len(after) != 2 {
+		t.Fatalf("after = %+v, want 2 directives", after)
+	}
+	if after[0].Op != "LABEL" || after[0].Args[0] != `version="1.0"` {
+		t.Errorf("after[0] = %+v, want LABEL with BASE_TAG expanded to 1.0", after[0])
+	}
+	if after[1].Op != "ENTRYPOINT" || !after[1].JSONForm || strings.Join(after[1].Args, ",") != "/bin/sh,-c,echo hi" {
+		t.Errorf("after[1] = %+v, want a joined JSON-form ENTRYPOINT", after[1])
+	}
+}
+
+func TestParseDockerfileTemplateRequiresMarker(t *testing.T) {
+	_, _, err := parseDockerfileTemplate("ARG FOO=bar\n")
+	if err == nil {
+		t.Fatal("expected error for template missing the inject marker")
+	}
+}
+
+func TestParseDockerfileTemplateRejectsDuplicateMarker(t *testing.T) {
+	data := "# godockerize:inject\n# godockerize:inject\n"
+	_, _, err := parseDockerfileTemplate(data)
+	if err == nil {
+		t.Fatal("expected error for a template with the inject marker twice")
+	}
+}
+
+func TestParseDockerfileTemplateDanglingContinuation(t *testing.T) {
+	data := "# godockerize:inject\nRUN foo \\"
+	_, _, err := parseDockerfileTemplate(data)
+	if err == nil {
+		t.Fatal("expected error for a dangling line continuation")
+	}
+}
+
+func TestMergeDirectivesSplicesAtMarker(t *testing.T) {
+	before := []Directive{{Op: "ARG", Args: []string{"BASE_TAG=1.0"}}}
+	generated := []Directive{
+		{Op: "FROM", Args: []string{"alpine:3.18"}},
+		{Op: "RUN", Args: []string{"apk add --no-cache tini"}},
+	}
+	after := []Directive{{Op: "LABEL", Args: []string{"maintainer=team"}}}
+
+	merged, err := mergeDirectives(before, generated, after)
+	if err != nil {
+		t.Fatalf("mergeDirectives: %s", err)
+	}
+	var ops []string
+	for _, d := range merged {
+		ops = append(ops, d.Op)
+	}
+	want := "ARG,FROM,RUN,LABEL"
+	if strings.Join(ops, ",") != want {
+		t.Errorf("merged ops = %v, want %s", ops, want)
+	}
+}
+
+func TestMergeDirectivesRejectsConflictingEntrypoint(t *testing.T) {
+	before := []Directive{{Op: "ENTRYPOINT", Args: []string{"/bin/custom"}, JSONForm: true}}
+	generated := []Directive{{Op: "ENTRYPOINT", Args: []string{"/usr/local/bin/foo"}, JSONForm: true}}
+
+	_, err := mergeDirectives(before, generated, nil)
+	if err == nil {
+		t.Fatal("expected error for a template that duplicates the generated ENTRYPOINT")
+	}
+}
+
+func TestMergeDirectivesRejectsTemplateFromBeforeMarker(t *testing.T) {
+	// A FROM in the template's "before" section would otherwise sit ahead of
+	// generated's own FROM with no error, silently starting a second,
+	// anonymous build stage.
+	before := []Directive{{Op: "FROM", Args: []string{"golang:1.21"}}}
+	generated := []Directive{{Op: "FROM", Args: []string{"alpine:3.18"}}}
+
+	_, err := mergeDirectives(before, generated, nil)
+	if err == nil {
+		t.Fatal("expected error for a template that sets its own FROM before the marker")
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/daemon"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// buildImageGGCR pulls base and assembles the image in-process with
+// go-containerregistry, without talking to a Docker daemon. See
+// assembleImageGGCR for how spec is rendered into the image config.
+//
+// Unlike the daemon backend, it can't run `apk add`: spec.Install must be
+// empty, so base has to already contain whatever packages the directives
+// would otherwise have installed.
+func buildImageGGCR(base, binDir string, spec *imageSpec) (v1.Image, error) {
+	if len(spec.Install) != 0 {
+		return nil, fmt.Errorf("--backend=ggcr can't run `apk add`; use a pre-baked --base image that already contains: %s", strings.Join(sortedStringSet(spec.Install), ", "))
+	}
+
+	baseImage, err := crane.Pull(base)
+	if err != nil {
+		return nil, fmt.Errorf("pulling base image %s: %s", base, err)
+	}
+
+	return assembleImageGGCR(baseImage, binDir, spec)
+}
+
+// assembleImageGGCR appends a layer with the compiled Go binaries in binDir
+// onto baseImage and sets the image config from spec, mirroring what
+// buildDirectives renders into a Dockerfile for the other backends. It's
+// split out from buildImageGGCR so it can be tested against a synthetic
+// baseImage (e.g. empty.Image) without pulling a real one.
+func assembleImageGGCR(baseImage v1.Image, binDir string, spec *imageSpec) (v1.Image, error) {
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return binTarball(binDir, spec.Packages)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("building binary layer: %s", err)
+	}
+
+	img, err := mutate.AppendLayers(baseImage, layer)
+	if err != nil {
+		return nil, fmt.Errorf("appending binary layer: %s", err)
+	}
+
+	cfgFile, err := img.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	cfg := cfgFile.Config
+	if spec.Entrypoint != "" {
+		var entrypoint []string
+		if err := json.Unmarshal([]byte(spec.Entrypoint), &entrypoint); err != nil {
+			return nil, fmt.Errorf("//docker:entrypoint must be a JSON array, e.g. [\"/usr/local/bin/foo\"]: %s", err)
+		}
+		cfg.Entrypoint = entrypoint
+	} else {
+		cfg.Entrypoint = []string{"/usr/local/bin/" + path.Base(spec.Packages[0])}
+	}
+	if spec.Cmd != "" {
+		cfg.Cmd = []string{"/bin/sh", "-c", spec.Cmd}
+	}
+	if len(spec.Env) != 0 {
+		cfg.Env = append(cfg.Env, sortedStringSet(spec.Env)...)
+	}
+	if spec.User != "" {
+		cfg.User = spec.User
+	}
+	if len(spec.Expose) != 0 {
+		if cfg.ExposedPorts == nil {
+			cfg.ExposedPorts = map[string]struct{}{}
+		}
+		for _, port := range sortedStringSet(spec.Expose) {
+			cfg.ExposedPorts[port+"/tcp"] = struct{}{}
+		}
+	}
+	if len(spec.Labels) != 0 {
+		if cfg.Labels == nil {
+			cfg.Labels = map[string]string{}
+		}
+		for _, label := range sortedStringSet(spec.Labels) {
+			k, v, ok := splitKeyValue(label)
+			if !ok {
+				return nil, fmt.Errorf("invalid //docker:label %q: expected key=value", label)
+			}
+			cfg.Labels[k] = v
+		}
+	}
+	if len(spec.Volumes) != 0 {
+		if cfg.Volumes == nil {
+			cfg.Volumes = map[string]struct{}{}
+		}
+		for _, v := range sortedStringSet(spec.Volumes) {
+			cfg.Volumes[v] = struct{}{}
+		}
+	}
+	if spec.Workdir != "" {
+		cfg.WorkingDir = spec.Workdir
+	}
+	if spec.Healthcheck != "" {
+		hc, err := parseHealthcheck(spec.Healthcheck)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Healthcheck = hc
+	}
+
+	return mutate.Config(img, cfg)
+}
+
+// parseHealthcheck parses a //docker:healthcheck directive body (the same
+// "--flag=value... command" syntax dockerfile.go's healthcheckCmd renders as
+// a Dockerfile HEALTHCHECK line) into the v1.HealthConfig the ggcr backend
+// needs, since it never renders an actual Dockerfile for Docker to parse.
+func parseHealthcheck(body string) (*v1.HealthConfig, error) {
+	fields := strings.Fields(body)
+	hc := &v1.HealthConfig{}
+	i := 0
+	for ; i < len(fields); i++ {
+		if !strings.HasPrefix(fields[i], "--") {
+			break
+		}
+		name, value, ok := splitKeyValue(strings.TrimPrefix(fields[i], "--"))
+		if !ok {
+			return nil, fmt.Errorf("invalid //docker:healthcheck flag %q: expected --flag=value", fields[i])
+		}
+		switch name {
+		case "interval", "timeout", "start-period":
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid //docker:healthcheck --%s %q: %s", name, value, err)
+			}
+			switch name {
+			case "interval":
+				hc.Interval = d
+			case "timeout":
+				hc.Timeout = d
+			case "start-period":
+				hc.StartPeriod = d
+			}
+		case "retries":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid //docker:healthcheck --retries %q: %s", value, err)
+			}
+			hc.Retries = n
+		default:
+			return nil, fmt.Errorf("invalid //docker:healthcheck flag %q: expected --interval, --timeout, --start-period, or --retries", fields[i])
+		}
+	}
+	if i == len(fields) {
+		return nil, fmt.Errorf("//docker:healthcheck has no command")
+	}
+	hc.Test = []string{"CMD-SHELL", strings.Join(fields[i:], " ")}
+	return hc, nil
+}
+
+// binTarball builds an uncompressed tar stream containing the compiled
+// binaries in binDir, rooted at /usr/local/bin/, for use as an image layer.
+func binTarball(binDir string, packages []string) (io.ReadCloser, error) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, importPath := range packages {
+		binName := path.Base(importPath)
+		f, err := os.Open(path.Join(binDir, binName))
+		if err != nil {
+			return nil, err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if err := tw.WriteHeader(&tar.Header{
+			Name: path.Join("usr/local/bin", binName),
+			Mode: 0755,
+			Size: info.Size(),
+		}); err != nil {
+			f.Close()
+			return nil, err
+		}
+		if _, err := io.Copy(tw, f); err != nil {
+			f.Close()
+			return nil, err
+		}
+		f.Close()
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(&buf), nil
+}
+
+// writeGGCROutput publishes img to one of the supported --output
+// destinations: docker-daemon://name:tag, oci-archive:path.tar, or
+// registry://ref.
+func writeGGCROutput(img v1.Image, output string) error {
+	switch {
+	case strings.HasPrefix(output, "docker-daemon://"):
+		tag, err := name.NewTag(strings.TrimPrefix(output, "docker-daemon://"))
+		if err != nil {
+			return err
+		}
+		_, err = daemon.Write(tag, img)
+		return err
+	case strings.HasPrefix(output, "oci-archive:"):
+		ref := strings.TrimPrefix(output, "oci-archive:")
+		return tarball.WriteToFile(ref, nil, img)
+	case strings.HasPrefix(output, "registry://"):
+		ref, err := name.ParseReference(strings.TrimPrefix(output, "registry://"))
+		if err != nil {
+			return err
+		}
+		return crane.Push(img, ref.String())
+	default:
+		return fmt.Errorf("invalid --output %q: expected docker-daemon://, oci-archive:, or registry:// prefix", output)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+)
+
+func TestBinTarball(t *testing.T) {
+	binDir := t.TempDir()
+	for name, content := range map[string]string{"foo": "foo binary", "bar": "bar binary"} {
+		if err := ioutil.WriteFile(filepath.Join(binDir, name), []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rc, err := binTarball(binDir, []string{"example.com/cmd/foo", "example.com/cmd/bar"})
+	if err != nil {
+		t.Fatalf("binTarball: %s", err)
+	}
+	defer rc.Close()
+
+	tr := tar.NewReader(rc)
+	type entry struct {
+		name string
+		mode int64
+		body string
+	}
+	var got []entry
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		body, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, entry{hdr.Name, hdr.Mode, string(body)})
+	}
+	sort.Slice(got, func(i, j int) bool { return got[i].name < got[j].name })
+
+	want := []entry{
+		{"usr/local/bin/bar", 0755, "bar binary"},
+		{"usr/local/bin/foo", 0755, "foo binary"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("tar entries = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestBinTarballMissingBinary(t *testing.T) {
+	rc, err := binTarball(t.TempDir(), []string{"example.com/cmd/missing"})
+	if err == nil {
+		rc.Close()
+		t.Fatal("expected error for a missing binary")
+	}
+}
+
+func TestBuildImageGGCRRejectsInstall(t *testing.T) {
+	spec := &imageSpec{Packages: []string{"example.com/cmd/foo"}, Install: []string{"curl"}}
+	if _, err := buildImageGGCR("alpine:3.18", t.TempDir(), spec); err == nil {
+		t.Fatal("expected error when spec.Install is non-empty")
+	}
+}
+
+func TestAssembleImageGGCR(t *testing.T) {
+	binDir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(binDir, "foo"), []byte("foo binary"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	spec := &imageSpec{
+		Packages:    []string{"example.com/cmd/foo"},
+		Env:         []string{"FOO=bar"},
+		Expose:      []string{"8080"},
+		User:        "app",
+		Cmd:         "foo serve",
+		Labels:      []string{"maintainer=team"},
+		Volumes:     []string{"/data"},
+		Workdir:     "/srv",
+		Healthcheck: "--interval=5s --retries=3 curl -f http://localhost/ || exit 1",
+	}
+
+	img, err := assembleImageGGCR(empty.Image, binDir, spec)
+	if err != nil {
+		t.Fatalf("assembleImageGGCR: %s", err)
+	}
+	cfgFile, err := img.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := cfgFile.Config
+
+	if len(cfg.Entrypoint) != 1 || cfg.Entrypoint[0] != "/usr/local/bin/foo" {
+		t.Errorf("Entrypoint = %v, want [/usr/local/bin/foo]", cfg.Entrypoint)
+	}
+	if len(cfg.Cmd) != 3 || cfg.Cmd[2] != "foo serve" {
+		t.Errorf("Cmd = %v, want a /bin/sh -c wrapper around %q", cfg.Cmd, "foo serve")
+	}
+	if len(cfg.Env) != 1 || cfg.Env[0] != "FOO=bar" {
+		t.Errorf("Env = %v, want [FOO=bar]", cfg.Env)
+	}
+	if cfg.User != "app" {
+		t.Errorf("User = %q, want app", cfg.User)
+	}
+	if _, ok := cfg.ExposedPorts["8080/tcp"]; !ok {
+		t.Errorf("ExposedPorts = %v, want 8080/tcp present", cfg.ExposedPorts)
+	}
+	if cfg.Labels["maintainer"] != "team" {
+		t.Errorf("Labels = %v, want maintainer=team", cfg.Labels)
+	}
+	if _, ok := cfg.Volumes["/data"]; !ok {
+		t.Errorf("Volumes = %v, want /data present", cfg.Volumes)
+	}
+	if cfg.WorkingDir != "/srv" {
+		t.Errorf("WorkingDir = %q, want /srv", cfg.WorkingDir)
+	}
+	if cfg.Healthcheck == nil {
+		t.Fatal("Healthcheck = nil, want set")
+	}
+	if cfg.Healthcheck.Interval.String() != "5s" {
+		t.Errorf("Healthcheck.Interval = %s, want 5s", cfg.Healthcheck.Interval)
+	}
+	if cfg.Healthcheck.Retries != 3 {
+		t.Errorf("Healthcheck.Retries = %d, want 3", cfg.Healthcheck.Retries)
+	}
+	if len(cfg.Healthcheck.Test) != 2 || cfg.Healthcheck.Test[0] != "CMD-SHELL" {
+		t.Errorf("Healthcheck.Test = %v, want a CMD-SHELL entry", cfg.Healthcheck.Test)
+	}
+}
+
+func TestAssembleImageGGCRCustomEntrypoint(t *testing.T) {
+	binDir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(binDir, "foo"), []byte("foo binary"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	spec := &imageSpec{
+		Packages:   []string{"example.com/cmd/foo"},
+		Entrypoint: `["/sbin/tini", "--", "/usr/local/bin/foo"]`,
+	}
+	img, err := assembleImageGGCR(empty.Image, binDir, spec)
+	if err != nil {
+		t.Fatalf("assembleImageGGCR: %s", err)
+	}
+	cfgFile, err := img.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/sbin/tini", "--", "/usr/local/bin/foo"}
+	got := cfgFile.Config.Entrypoint
+	if len(got) != len(want) {
+		t.Fatalf("Entrypoint = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Entrypoint = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestParseHealthcheck(t *testing.T) {
+	hc, err := parseHealthcheck("--interval=5s --timeout=3s --start-period=1s --retries=2 curl -f http://localhost/")
+	if err != nil {
+		t.Fatalf("parseHealthcheck: %s", err)
+	}
+	if hc.Interval.String() != "5s" || hc.Timeout.String() != "3s" || hc.StartPeriod.String() != "1s" || hc.Retries != 2 {
+		t.Errorf("parsed flags = %+v, want interval=5s timeout=3s start-period=1s retries=2", hc)
+	}
+	if len(hc.Test) != 2 || hc.Test[0] != "CMD-SHELL" || hc.Test[1] != "curl -f http://localhost/" {
+		t.Errorf("Test = %v", hc.Test)
+	}
+}
+
+func TestParseHealthcheckErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"--interval=5s",
+		"--bogus=1 curl -f http://localhost/",
+		"--retries=notanumber curl -f http://localhost/",
+	}
+	for _, body := range tests {
+		if _, err := parseHealthcheck(body); err == nil {
+			t.Errorf("parseHealthcheck(%q): expected error", body)
+		}
+	}
+}
+
+func TestWriteGGCROutputInvalidOutput(t *testing.T) {
+	if err := writeGGCROutput(empty.Image, "not-a-valid-output"); err == nil {
+		t.Fatal("expected error for an --output without a recognized scheme")
+	}
+}
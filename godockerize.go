@@ -1,9 +1,9 @@
+//go:build go1.10
 // +build go1.10
 
 package main
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
 	"go/build"
@@ -46,6 +46,10 @@ func main() {
 						Usage: "base Docker image name",
 						Value: baseDockerImage,
 					},
+					&cli.StringFlag{
+						Name:  "base-family",
+						Usage: "override the base image family detected from --base: alpine, debian, fedora, or distroless",
+					},
 					&cli.StringSliceFlag{
 						Name:  "env",
 						Usage: "additional environment variables for the Dockerfile",
@@ -54,6 +58,40 @@ func main() {
 						Name:  "go-build-flags",
 						Usage: "additional flags to pass to go build",
 					},
+					&cli.StringSliceFlag{
+						Name:  "platform",
+						Usage: "target platform to build for, in 'os/arch[/variant]' form (e.g. linux/amd64, linux/arm64, linux/arm/v7); may be repeated to build a multi-arch manifest list",
+					},
+					&cli.BoolFlag{
+						Name:  "use-buildx",
+						Usage: "stitch multi-arch manifests with `docker buildx imagetools create` instead of `docker manifest`",
+					},
+					&cli.BoolFlag{
+						Name:  "multi-stage",
+						Usage: "build the Go binaries inside the image with a `golang` builder stage, instead of on the host",
+					},
+					&cli.StringFlag{
+						Name:  "go-version",
+						Usage: "version of the `golang` image to use as the builder stage in --multi-stage mode",
+						Value: defaultGoVersion,
+					},
+					&cli.StringFlag{
+						Name:  "build-context",
+						Usage: "directory to use as the Docker build context in --multi-stage mode (default: the Go module root, via `go list -m`)",
+					},
+					&cli.StringFlag{
+						Name:  "backend",
+						Usage: "image build backend: `daemon` (docker build, default), `ggcr` (assemble the image in-process, no daemon required), or `buildah`",
+						Value: "daemon",
+					},
+					&cli.StringFlag{
+						Name:  "output",
+						Usage: "where to publish the image in --backend=ggcr mode: docker-daemon://name:tag, oci-archive:path.tar, or registry://ref",
+					},
+					&cli.StringFlag{
+						Name:  "dockerfile-template",
+						Usage: "path to a Dockerfile containing a '# godockerize:inject' marker line; godockerize's generated directives are spliced in at the marker, and everything else in the template (COPY, HEALTHCHECK, LABEL, ARG, ONBUILD, ...) is kept as-is",
+					},
 					&cli.BoolFlag{
 						Name:  "dry-run",
 						Usage: "only print generated Dockerfile",
@@ -92,8 +130,8 @@ func doBuild(c *cli.Context) error {
 	defer os.RemoveAll(tmpdir)
 
 	var (
-		packages, expose, repos, run, userDirs []string
-		user, cmd                              string
+		packages, expose, repos, run, userDirs, labels, volumes, preArgs, postArgs []string
+		user, cmd, workdir, healthcheck, entrypoint                                string
 
 		fset    = token.NewFileSet()
 		env     = c.StringSlice("env")
@@ -159,6 +197,53 @@ func doBuild(c *cli.Context) error {
 							} else {
 								fmt.Printf("%s: ignoring user directive since %s is not the first package\n", fset.Position(c.Pos()), pkgName)
 							}
+						case "workdir":
+							if isFirstPackage {
+								if workdir != "" {
+									return errors.New("workdir set twice")
+								}
+								workdir = parts[1]
+							} else {
+								fmt.Printf("%s: ignoring workdir directive since %s is not the first package\n", fset.Position(c.Pos()), pkgName)
+							}
+						case "entrypoint":
+							if isFirstPackage {
+								if entrypoint != "" {
+									return errors.New("entrypoint set twice")
+								}
+								entrypoint = parts[1]
+							} else {
+								fmt.Printf("%s: ignoring entrypoint directive since %s is not the first package\n", fset.Position(c.Pos()), pkgName)
+							}
+						case "healthcheck":
+							if isFirstPackage {
+								if healthcheck != "" {
+									return errors.New("healthcheck set twice")
+								}
+								healthcheck = parts[1]
+							} else {
+								fmt.Printf("%s: ignoring healthcheck directive since %s is not the first package\n", fset.Position(c.Pos()), pkgName)
+							}
+						case "label":
+							if isFirstPackage {
+								labels = append(labels, strings.Fields(parts[1])...)
+							} else {
+								fmt.Printf("%s: ignoring label directive since %s is not the first package\n", fset.Position(c.Pos()), pkgName)
+							}
+						case "volume":
+							if isFirstPackage {
+								volumes = append(volumes, strings.Fields(parts[1])...)
+							} else {
+								fmt.Printf("%s: ignoring volume directive since %s is not the first package\n", fset.Position(c.Pos()), pkgName)
+							}
+						case "arg":
+							for _, a := range strings.Fields(parts[1]) {
+								if strings.Contains(a, "=") {
+									postArgs = append(postArgs, a)
+								} else {
+									preArgs = append(preArgs, a)
+								}
+							}
 						default:
 							return fmt.Errorf("%s: invalid docker comment: %s", fset.Position(c.Pos()), c.Text)
 						}
@@ -168,104 +253,152 @@ func doBuild(c *cli.Context) error {
 		}
 	}
 
-	var dockerfile bytes.Buffer
-	fmt.Fprintf(&dockerfile, "  FROM %s\n", c.String("base"))
-
-	for _, pkg := range install {
-		if strings.HasSuffix(pkg, "@edge") {
-			fmt.Fprintf(&dockerfile, `  RUN echo -e "@edge http://dl-cdn.alpinelinux.org/alpine/edge/main\n" >> /etc/apk/repositories && \
-    echo -e "@edge http://dl-cdn.alpinelinux.org/alpine/edge/community\n" >> /etc/apk/repositories
-`)
-			break
-		}
+	spec := &imageSpec{
+		Packages: packages, Install: install, Env: env, Expose: expose, Repos: repos, Run: run, UserDirs: userDirs,
+		Labels: labels, Volumes: volumes, PreArgs: preArgs, PostArgs: postArgs,
+		User: user, Cmd: cmd, Workdir: workdir, Healthcheck: healthcheck, Entrypoint: entrypoint,
 	}
-	for i := range repos {
-		fmt.Fprintf(&dockerfile, `  RUN echo -e "http://dl-cdn.alpinelinux.org/alpine/%s/main\n" >> /etc/apk/repositories && \
-    echo -e "http://dl-cdn.alpinelinux.org/alpine/%s/community\n" >> /etc/apk/repositories
-`, repos[i], repos[i])
-	}
-	if strings.HasPrefix(c.String("base"), "alpine") {
-		// IMPORTANT: Alpine by default does not come with some packages that
-		// are needed for working DNS to other containers on a user-defined
-		// Docker network. Without installing this package, nslookup and Go etc
-		// will fail to contact other Docker containers.
-		// See https://github.com/sourcegraph/deploy-sourcegraph-docker/issues/1
-		install = append(install, "bind-tools")
-	}
-	if len(install) != 0 {
-		fmt.Fprintf(&dockerfile, "  RUN apk add --no-cache %s\n", strings.Join(sortedStringSet(install), " "))
+
+	platformArgs := c.StringSlice("platform")
+	multiArch := len(platformArgs) > 0
+	if !multiArch {
+		platformArgs = []string{"linux/amd64"}
 	}
-	if user != "" {
-		runCmds := []string{fmt.Sprintf("addgroup -S %s && adduser -S -G %s -h /home/%s %s", user, user, user, user)}
-		for _, userDir := range userDirs {
-			runCmds = append(runCmds, fmt.Sprintf("mkdir -p %s && chown -R %s:%s %s", userDir, user, user, userDir))
+	platforms := make([]Platform, len(platformArgs))
+	for i, p := range platformArgs {
+		platform, err := ParsePlatform(p)
+		if err != nil {
+			return err
 		}
-		fmt.Fprintf(&dockerfile, "  RUN "+strings.Join(runCmds, " && ")+"\n")
-	}
-	for _, cmd := range run {
-		fmt.Fprintf(&dockerfile, "  RUN %s\n", cmd)
+		platforms[i] = platform
 	}
-	if len(env) != 0 {
-		fmt.Fprintf(&dockerfile, "  ENV %s\n", strings.Join(sortedStringSet(env), " "))
-	}
-	if len(expose) != 0 {
-		fmt.Fprintf(&dockerfile, "  EXPOSE %s\n", strings.Join(sortedStringSet(expose), " "))
-	}
-	if user != "" {
-		fmt.Fprintf(&dockerfile, "  USER %s\n", user)
+
+	multiStage := c.Bool("multi-stage")
+	var buildContextDir string
+	if multiStage {
+		buildContextDir = c.String("build-context")
+		if buildContextDir == "" {
+			buildContextDir, err = discoverModuleRoot(wd)
+			if err != nil {
+				return err
+			}
+		}
 	}
-	if cmd != "" {
-		fmt.Fprintf(&dockerfile, "  CMD %s\n", cmd)
+
+	backend := c.String("backend")
+	if backend == "" {
+		backend = "daemon"
 	}
-	fmt.Fprintf(&dockerfile, "  ENTRYPOINT [\"/sbin/tini\", \"--\", \"/usr/local/bin/%s\"]\n", path.Base(packages[0]))
-	for _, importPath := range packages {
-		fmt.Fprintf(&dockerfile, "  ADD %s /usr/local/bin/\n", path.Base(importPath))
+	if backend == "ggcr" {
+		if multiArch {
+			return errors.New("--backend=ggcr does not support multiple --platform values; build and push each arch separately")
+		}
+		if multiStage {
+			return errors.New("--backend=ggcr can't be combined with --multi-stage; it builds binaries on the host")
+		}
+		if c.String("output") == "" {
+			return errors.New("--backend=ggcr requires --output")
+		}
+		if c.String("dockerfile-template") != "" {
+			return errors.New("--backend=ggcr can't be combined with --dockerfile-template; it never renders an actual Dockerfile for the template to splice into")
+		}
 	}
 
-	fmt.Println("godockerize: Generated Dockerfile:")
-	fmt.Print(dockerfile.String())
+	baseTag := c.String("tag")
+	platformTags := make([]string, len(platforms))
+	for i, platform := range platforms {
+		tag := baseTag
+		if multiArch && tag != "" {
+			tag = platform.Tag(tag)
+		}
+		platformTags[i] = tag
 
-	if c.Bool("dry-run") {
-		return nil
-	}
+		dockerfile, err := generateDockerfile(c, platform, spec, multiStage, c.String("go-version"))
+		if err != nil {
+			return err
+		}
 
-	ioutil.WriteFile(filepath.Join(tmpdir, "Dockerfile"), dockerfile.Bytes(), 0777)
-	if err != nil {
-		return err
-	}
+		fmt.Printf("godockerize: Generated Dockerfile for %s:\n", platformArgs[i])
+		fmt.Print(dockerfile.String())
 
-	for _, importPath := range packages {
-		fmt.Printf("godockerize: Building Go binary %s...\n", path.Base(importPath))
-		args := append([]string{"build"}, c.StringSlice("go-build-flags")...)
-		args = append(args, "-buildmode", "exe", "-tags", "dist", "-o", filepath.Join(tmpdir, path.Base(importPath)), importPath)
-		cmd := exec.Command("go", args...)
-		cmd.Dir = wd
-		cmd.Env = []string{
-			"GOARCH=amd64",
-			"GOOS=linux",
-			"GOROOT=" + build.Default.GOROOT,
-			"GOPATH=" + build.Default.GOPATH,
-			"GOCACHE=" + strings.TrimSpace(string(gocache)),
-			"CGO_ENABLED=0",
+		if c.Bool("dry-run") {
+			continue
 		}
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if err := cmd.Run(); err != nil {
+
+		archDir := filepath.Join(tmpdir, platform.ArchSuffix())
+		if err := os.MkdirAll(archDir, 0777); err != nil {
+			return err
+		}
+		dockerfilePath := filepath.Join(archDir, "Dockerfile")
+		if err := ioutil.WriteFile(dockerfilePath, dockerfile.Bytes(), 0777); err != nil {
 			return err
 		}
+
+		if !multiStage {
+			for _, importPath := range packages {
+				fmt.Printf("godockerize: Building Go binary %s for %s...\n", path.Base(importPath), platformArgs[i])
+				args := append([]string{"build"}, c.StringSlice("go-build-flags")...)
+				args = append(args, "-buildmode", "exe", "-tags", "dist", "-o", filepath.Join(archDir, path.Base(importPath)), importPath)
+				goBuild := exec.Command("go", args...)
+				goBuild.Dir = wd
+				goBuild.Env = []string{
+					"GOARCH=" + platform.GOARCH(),
+					"GOARM=" + platform.GOARM(),
+					"GOOS=linux",
+					"GOROOT=" + build.Default.GOROOT,
+					"GOPATH=" + build.Default.GOPATH,
+					"GOCACHE=" + strings.TrimSpace(string(gocache)),
+					"CGO_ENABLED=0",
+				}
+				goBuild.Stdout = os.Stdout
+				goBuild.Stderr = os.Stderr
+				if err := goBuild.Run(); err != nil {
+					return err
+				}
+			}
+		}
+
+		if backend == "ggcr" {
+			fmt.Printf("godockerize: Assembling image for %s with --backend=ggcr...\n", platformArgs[i])
+			img, err := buildImageGGCR(c.String("base"), archDir, spec)
+			if err != nil {
+				return err
+			}
+			if err := writeGGCROutput(img, c.String("output")); err != nil {
+				return err
+			}
+			continue
+		}
+
+		fmt.Printf("godockerize: Building Docker image for %s...\n", platformArgs[i])
+		contextDir := archDir
+		if multiStage {
+			contextDir = buildContextDir
+		}
+		if err := runImageBuild(backend, dockerfilePath, contextDir, platformTags[i], map[string]string{"TARGETARCH": platform.TargetArch()}); err != nil {
+			return err
+		}
+
+		if multiArch && baseTag != "" {
+			fmt.Printf("godockerize: Pushing %s...\n", platformTags[i])
+			if err := pushImage(backend, platformTags[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	if c.Bool("dry-run") {
+		return nil
 	}
 
-	fmt.Println("godockerize: Building Docker image...")
-	dockerArgs := []string{"build"}
-	if tag := c.String("tag"); tag != "" {
-		dockerArgs = append(dockerArgs, "-t", tag)
+	if multiArch && baseTag != "" {
+		fmt.Println("godockerize: Building multi-arch manifest list...")
+		if err := buildManifest(baseTag, platforms, platformTags, c.Bool("use-buildx")); err != nil {
+			return err
+		}
 	}
-	dockerArgs = append(dockerArgs, ".")
-	docker := exec.Command("docker", dockerArgs...)
-	docker.Dir = tmpdir
-	docker.Stdout = os.Stdout
-	docker.Stderr = os.Stderr
-	return docker.Run()
+
+	return nil
 }
 
 func sortedStringSet(in []string) []string {
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/urfave/cli"
+)
+
+// newDoBuildContext builds a *cli.Context wired up with the same flags
+// doBuild reads, parsing args as its positional package arguments (plus any
+// leading flag arguments such as "-dry-run").
+func newDoBuildContext(t *testing.T, args []string) *cli.Context {
+	t.Helper()
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := []cli.Flag{
+		&cli.StringFlag{Name: "tag"},
+		&cli.StringFlag{Name: "base", Value: "alpine:3.18"},
+		&cli.StringFlag{Name: "base-family"},
+		&cli.StringSliceFlag{Name: "env"},
+		&cli.StringSliceFlag{Name: "go-build-flags"},
+		&cli.StringSliceFlag{Name: "platform"},
+		&cli.BoolFlag{Name: "use-buildx"},
+		&cli.BoolFlag{Name: "multi-stage"},
+		&cli.StringFlag{Name: "go-version", Value: defaultGoVersion},
+		&cli.StringFlag{Name: "build-context"},
+		&cli.StringFlag{Name: "backend", Value: "daemon"},
+		&cli.StringFlag{Name: "output"},
+		&cli.StringFlag{Name: "dockerfile-template"},
+		&cli.BoolFlag{Name: "dry-run"},
+	}
+	for _, f := range flags {
+		f.Apply(set)
+	}
+	if err := set.Parse(args); err != nil {
+		t.Fatalf("parsing flags: %s", err)
+	}
+	return cli.NewContext(nil, set, nil)
+}
+
+// chdir changes the working directory for the duration of the test, since
+// doBuild resolves its package arguments relative to os.Getwd().
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	orig, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := os.Chdir(orig); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// writeFixturePackage writes a trivial Go package directory named dir
+// (relative to root) containing the given //docker: directive comments.
+func writeFixturePackage(t *testing.T, root, dir string, directives ...string) {
+	t.Helper()
+	pkgDir := filepath.Join(root, dir)
+	if err := os.MkdirAll(pkgDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	var src strings.Builder
+	src.WriteString("package main\n\n")
+	for _, d := range directives {
+		src.WriteString("//docker:" + d + "\n")
+	}
+	src.WriteString("\nfunc main() {}\n")
+	if err := ioutil.WriteFile(filepath.Join(pkgDir, "main.go"), []byte(src.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// captureStdout runs fn with os.Stdout redirected to a pipe and returns
+// everything written to it.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	orig := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = w
+	fn()
+	w.Close()
+	os.Stdout = orig
+
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, r); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestDoBuildDirectiveSetTwiceErrors(t *testing.T) {
+	tests := []struct {
+		directive string
+		wantErr   string
+	}{
+		{"cmd foo", "cmd set twice"},
+		{"user app", "user set twice"},
+		{"workdir /app", "workdir set twice"},
+		{"entrypoint [\"/bin/foo\"]", "entrypoint set twice"},
+		{"healthcheck curl -f http://localhost/", "healthcheck set twice"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.wantErr, func(t *testing.T) {
+			root := t.TempDir()
+			writeFixturePackage(t, root, "pkg", tc.directive, tc.directive)
+			chdir(t, root)
+
+			c := newDoBuildContext(t, []string{"-dry-run", "./pkg"})
+			err := doBuild(c)
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("doBuild() error = %v, want it to contain %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestDoBuildOnlyFirstPackageDirectivesApply(t *testing.T) {
+	root := t.TempDir()
+	writeFixturePackage(t, root, "first", "workdir /app", "cmd first-cmd")
+	writeFixturePackage(t, root, "second", "workdir /other", "cmd second-cmd")
+	chdir(t, root)
+
+	c := newDoBuildContext(t, []string{"-dry-run", "./first", "./second"})
+	var buildErr error
+	out := captureStdout(t, func() {
+		buildErr = doBuild(c)
+	})
+	if buildErr != nil {
+		t.Fatalf("doBuild(): %s", buildErr)
+	}
+
+	if !strings.Contains(out, "  WORKDIR /app\n") {
+		t.Errorf("generated Dockerfile missing WORKDIR /app:\n%s", out)
+	}
+	if strings.Contains(out, "/other") {
+		t.Errorf("generated Dockerfile used the second package's workdir, want it ignored:\n%s", out)
+	}
+	if !strings.Contains(out, "  CMD first-cmd\n") {
+		t.Errorf("generated Dockerfile missing CMD first-cmd:\n%s", out)
+	}
+	if strings.Contains(out, "second-cmd") {
+		t.Errorf("generated Dockerfile used the second package's cmd, want it ignored:\n%s", out)
+	}
+	if !strings.Contains(out, "ignoring workdir directive since ./second is not the first package") {
+		t.Errorf("expected an \"ignoring workdir directive\" notice for the second package:\n%s", out)
+	}
+	if !strings.Contains(out, "ignoring cmd directive since ./second is not the first package") {
+		t.Errorf("expected an \"ignoring cmd directive\" notice for the second package:\n%s", out)
+	}
+}
+
+func TestDoBuildInvalidDirective(t *testing.T) {
+	root := t.TempDir()
+	writeFixturePackage(t, root, "pkg", "bogus whatever")
+	chdir(t, root)
+
+	c := newDoBuildContext(t, []string{"-dry-run", "./pkg"})
+	err := doBuild(c)
+	if err == nil || !strings.Contains(err.Error(), "invalid docker comment") {
+		t.Fatalf("doBuild() error = %v, want an invalid docker comment error", err)
+	}
+}
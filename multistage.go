@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// defaultGoVersion is used to pin the `golang` builder image in --multi-stage
+// mode when --go-version isn't given.
+const defaultGoVersion = "1.13"
+
+// discoverModuleRoot returns the root directory of the Go module containing
+// wd, as reported by `go list -m`. It's used as the default Docker build
+// context in --multi-stage mode, since the builder stage needs the whole
+// module source tree, not just the importing package's directory.
+func discoverModuleRoot(wd string) (string, error) {
+	cmd := exec.Command("go", "list", "-m", "-f", "{{.Dir}}")
+	cmd.Dir = wd
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("could not run `go list -m` (are you inside a Go module?): %s", err)
+	}
+	dir := strings.TrimSpace(string(out))
+	if dir == "" {
+		return "", fmt.Errorf("`go list -m` did not report a module root for %s", wd)
+	}
+	return dir, nil
+}
+
+// writeBuilderStage renders the `FROM golang:... AS builder` stage that
+// compiles packages inside the image, honoring goBuildFlags and the given
+// platform's TARGETARCH.
+func writeBuilderStage(dockerfile *bytes.Buffer, goVersion string, packages, goBuildFlags []string, platform Platform) {
+	fmt.Fprintf(dockerfile, "  FROM golang:%s AS builder\n", goVersion)
+	fmt.Fprintf(dockerfile, "  ARG TARGETARCH=%s\n", platform.TargetArch())
+	fmt.Fprintf(dockerfile, "  WORKDIR /src\n")
+	fmt.Fprintf(dockerfile, "  COPY . .\n")
+	fmt.Fprintf(dockerfile, "  ENV CGO_ENABLED=0 GOOS=linux GOARCH=$TARGETARCH")
+	if goarm := platform.GOARM(); goarm != "" {
+		fmt.Fprintf(dockerfile, " GOARM=%s", goarm)
+	}
+	fmt.Fprintf(dockerfile, "\n")
+
+	buildArgs := append([]string{"-tags", "dist"}, goBuildFlags...)
+	for _, importPath := range packages {
+		fmt.Fprintf(dockerfile, "  RUN go build %s -o /out/%s %s\n", strings.Join(buildArgs, " "), path.Base(importPath), importPath)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// TestDiscoverModuleRootUsesWd is a regression test: discoverModuleRoot must
+// run `go list -m` with cmd.Dir set to wd, not the process's own working
+// directory, so it reports the module containing wd even when that's not
+// where godockerize itself was invoked from.
+func TestDiscoverModuleRootUsesWd(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake go script is POSIX shell only")
+	}
+
+	binDir := t.TempDir()
+	script := "#!/bin/sh\necho \"$PWD\"\n"
+	if err := ioutil.WriteFile(filepath.Join(binDir, "go"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	wd := t.TempDir()
+	// Resolve symlinks (e.g. /tmp -> /private/tmp on macOS) so the comparison
+	// below matches what the fake `go` script's $PWD reports.
+	resolvedWd, err := filepath.EvalSymlinks(wd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := discoverModuleRoot(wd)
+	if err != nil {
+		t.Fatalf("discoverModuleRoot: %s", err)
+	}
+	if dir != resolvedWd {
+		t.Errorf("discoverModuleRoot(%q) ran `go list -m` from %q, want %q", wd, dir, resolvedWd)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Platform is a parsed --platform value, e.g. "linux/arm/v7".
+type Platform struct {
+	OS      string
+	Arch    string
+	Variant string
+}
+
+// ParsePlatform parses a Docker-style platform string ("os/arch[/variant]")
+// into its components. Only linux platforms are supported since that's all
+// the base images we generate Dockerfiles for support.
+func ParsePlatform(s string) (Platform, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return Platform{}, fmt.Errorf("invalid platform %q: expected os/arch or os/arch/variant", s)
+	}
+	p := Platform{OS: parts[0], Arch: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	if p.OS != "linux" {
+		return Platform{}, fmt.Errorf("invalid platform %q: only linux is supported", s)
+	}
+	switch p.Arch {
+	case "amd64", "arm64", "386":
+	case "arm":
+		if p.Variant == "" {
+			p.Variant = "v7"
+		}
+	default:
+		return Platform{}, fmt.Errorf("invalid platform %q: unsupported arch %q", s, p.Arch)
+	}
+	return p, nil
+}
+
+// GOARCH returns the GOARCH value to cross-compile for this platform.
+func (p Platform) GOARCH() string {
+	return p.Arch
+}
+
+// GOARM returns the GOARM value to cross-compile for this platform, or "" if
+// not applicable.
+func (p Platform) GOARM() string {
+	if p.Arch != "arm" || p.Variant == "" {
+		return ""
+	}
+	return strings.TrimPrefix(p.Variant, "v")
+}
+
+// ArchSuffix returns a short suffix identifying this platform's
+// architecture, suitable for use in a per-arch image tag (e.g. "amd64",
+// "arm64", "armv7").
+func (p Platform) ArchSuffix() string {
+	if p.Variant != "" {
+		return p.Arch + p.Variant
+	}
+	return p.Arch
+}
+
+// Tag returns the per-architecture image tag derived from the given base
+// tag, e.g. Platform{Arch: "arm64"}.Tag("my/image:latest") ==
+// "my/image:latest-arm64", and Platform{Arch: "arm64"}.Tag("my/image") ==
+// "my/image:latest-arm64" (Docker defaults an untagged reference to
+// "latest"). The arch suffix is appended to the tag component, not the
+// repository name, so a bare "myrepo/image" doesn't turn into the
+// differently-named "myrepo/image-amd64".
+func (p Platform) Tag(baseTag string) string {
+	name, tag := splitTag(baseTag)
+	return name + ":" + tag + "-" + p.ArchSuffix()
+}
+
+// splitTag splits a Docker image reference into its repository name and tag,
+// defaulting the tag to "latest" if none is given. It only treats a ":"
+// after the last "/" as the tag separator, so a "host:port/repo" prefix
+// isn't mistaken for one. A "@digest" suffix is stripped before looking for
+// a tag, since a digest reference has no tag of its own.
+func splitTag(ref string) (name, tag string) {
+	if at := strings.Index(ref, "@"); at != -1 {
+		ref = ref[:at]
+	}
+	lastSlash := strings.LastIndex(ref, "/")
+	lastColon := strings.LastIndex(ref, ":")
+	if lastColon > lastSlash {
+		return ref[:lastColon], ref[lastColon+1:]
+	}
+	return ref, "latest"
+}
+
+// TargetArch returns the value to pass as the TARGETARCH build-arg, matching
+// the convention used by Docker's own multi-platform build args.
+func (p Platform) TargetArch() string {
+	return p.Arch
+}
+
+// buildManifest stitches the per-platform images named by platformTags into
+// a single multi-arch manifest list published as tag, using `docker
+// manifest` (or `docker buildx imagetools` when useBuildx is set).
+func buildManifest(tag string, platforms []Platform, platformTags []string, useBuildx bool) error {
+	if useBuildx {
+		args := append([]string{"buildx", "imagetools", "create", "-t", tag}, platformTags...)
+		return runDockerCmd(args...)
+	}
+
+	createArgs := append([]string{"manifest", "create", tag}, platformTags...)
+	if err := runDockerCmd(createArgs...); err != nil {
+		return err
+	}
+	for i, p := range platforms {
+		annotateArgs := []string{"manifest", "annotate", tag, platformTags[i], "--os", p.OS, "--arch", p.Arch}
+		if p.Variant != "" {
+			annotateArgs = append(annotateArgs, "--variant", p.Variant)
+		}
+		if err := runDockerCmd(annotateArgs...); err != nil {
+			return err
+		}
+	}
+	return runDockerCmd("manifest", "push", tag)
+}
+
+func runDockerCmd(args ...string) error {
+	cmd := exec.Command("docker", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
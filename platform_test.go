@@ -0,0 +1,136 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestParsePlatform(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Platform
+		wantErr bool
+	}{
+		{"linux/amd64", Platform{OS: "linux", Arch: "amd64"}, false},
+		{"linux/arm64", Platform{OS: "linux", Arch: "arm64"}, false},
+		{"linux/arm/v7", Platform{OS: "linux", Arch: "arm", Variant: "v7"}, false},
+		{"linux/arm", Platform{OS: "linux", Arch: "arm", Variant: "v7"}, false},
+		{"windows/amd64", Platform{}, true},
+		{"linux/mips", Platform{}, true},
+		{"linux", Platform{}, true},
+	}
+	for _, tc := range tests {
+		got, err := ParsePlatform(tc.in)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("ParsePlatform(%q): expected error, got %+v", tc.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParsePlatform(%q): unexpected error: %s", tc.in, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("ParsePlatform(%q) = %+v, want %+v", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestPlatformTag(t *testing.T) {
+	tests := []struct {
+		platform Platform
+		baseTag  string
+		want     string
+	}{
+		{Platform{Arch: "amd64"}, "my/image:latest", "my/image:latest-amd64"},
+		{Platform{Arch: "arm64"}, "myrepo/image", "myrepo/image:latest-arm64"},
+		{Platform{Arch: "arm", Variant: "v7"}, "myrepo/image:v1", "myrepo/image:v1-armv7"},
+		{Platform{Arch: "amd64"}, "registry:5000/myrepo/image", "registry:5000/myrepo/image:latest-amd64"},
+		{Platform{Arch: "amd64"}, "registry:5000/myrepo/image:v1", "registry:5000/myrepo/image:v1-amd64"},
+		{Platform{Arch: "amd64"}, "myrepo/image@sha256:deadbeef", "myrepo/image:latest-amd64"},
+	}
+	for _, tc := range tests {
+		if got := tc.platform.Tag(tc.baseTag); got != tc.want {
+			t.Errorf("%+v.Tag(%q) = %q, want %q", tc.platform, tc.baseTag, got, tc.want)
+		}
+	}
+}
+
+// writeFakeDocker installs a fake `docker` binary on PATH that appends every
+// invocation's arguments, one per line, to logPath, so buildManifest's
+// `docker manifest create`/`annotate`/`push` (or buildx) sequence can be
+// asserted on without a real daemon.
+func writeFakeDocker(t *testing.T, logPath string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake docker script is POSIX shell only")
+	}
+	dir := t.TempDir()
+	script := "#!/bin/sh\necho \"$@\" >> " + logPath + "\n"
+	fakeDocker := filepath.Join(dir, "docker")
+	if err := ioutil.WriteFile(fakeDocker, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestBuildManifestInvocationSequence(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "docker.log")
+	writeFakeDocker(t, logPath)
+
+	platforms := []Platform{
+		{OS: "linux", Arch: "amd64"},
+		{OS: "linux", Arch: "arm", Variant: "v7"},
+	}
+	platformTags := []string{"my/image:latest-amd64", "my/image:latest-armv7"}
+
+	if err := buildManifest("my/image:latest", platforms, platformTags, false); err != nil {
+		t.Fatalf("buildManifest: %s", err)
+	}
+
+	log, err := ioutil.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimRight(string(log), "\n"), "\n")
+	wantPrefixes := []string{
+		"manifest create my/image:latest my/image:latest-amd64 my/image:latest-armv7",
+		"manifest annotate my/image:latest my/image:latest-amd64 --os linux --arch amd64",
+		"manifest annotate my/image:latest my/image:latest-armv7 --os linux --arch arm --variant v7",
+		"manifest push my/image:latest",
+	}
+	if len(lines) != len(wantPrefixes) {
+		t.Fatalf("docker invoked %d times, want %d: %v", len(lines), len(wantPrefixes), lines)
+	}
+	for i, want := range wantPrefixes {
+		if lines[i] != want {
+			t.Errorf("invocation %d = %q, want %q", i, lines[i], want)
+		}
+	}
+}
+
+func TestBuildManifestUsesBuildxImagetools(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "docker.log")
+	writeFakeDocker(t, logPath)
+
+	platforms := []Platform{{OS: "linux", Arch: "amd64"}}
+	platformTags := []string{"my/image:latest-amd64"}
+
+	if err := buildManifest("my/image:latest", platforms, platformTags, true); err != nil {
+		t.Fatalf("buildManifest: %s", err)
+	}
+
+	log, err := ioutil.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "buildx imagetools create -t my/image:latest my/image:latest-amd64\n"
+	if string(log) != want {
+		t.Errorf("docker invocations = %q, want %q", log, want)
+	}
+}
@@ -0,0 +1,10 @@
+package main
+
+// imageSpec holds everything doBuild collects from //docker: comments
+// across the built packages; generateDockerfile and the ggcr backend both
+// render from it.
+type imageSpec struct {
+	Packages, Install, Env, Expose, Repos, Run, UserDirs []string
+	Labels, Volumes, PreArgs, PostArgs                   []string
+	User, Cmd, Workdir, Healthcheck, Entrypoint          string
+}